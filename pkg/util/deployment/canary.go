@@ -0,0 +1,264 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/release_1_2"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// CanaryDeploymentStrategyType is a DeploymentStrategyType that rolls the new RC out through an
+// ordered list of batches, pausing between batches for either a fixed duration or an explicit
+// human resume. It extends the strategy types declared in pkg/apis/extensions/types.go.
+const CanaryDeploymentStrategyType extensions.DeploymentStrategyType = "Canary"
+
+const (
+	// CanaryStepsAnnotation carries the JSON-encoded ordered list of CanarySteps for a canary
+	// rollout. It is set once, when the rollout is started, and not mutated afterwards.
+	CanaryStepsAnnotation = "deployment.kubernetes.io/canary-steps"
+
+	// CanaryStepIndexAnnotation records the index into CanaryStepsAnnotation that the rollout
+	// last grew the new RC to, so a controller restart resumes mid-rollout instead of
+	// restarting from the first step.
+	CanaryStepIndexAnnotation = "deployment.kubernetes.io/canary-step-index"
+
+	// CanaryStepTransitionAnnotation records when the rollout last advanced to
+	// CanaryStepIndexAnnotation, so a timed Pause can be resumed across controller restarts.
+	CanaryStepTransitionAnnotation = "deployment.kubernetes.io/canary-step-transition-time"
+
+	// CanaryPausedAnnotation is the explicit human resume gate for a step whose Pause has no
+	// Duration. The controller treats the step as paused unless this annotation is present and
+	// set to "false".
+	CanaryPausedAnnotation = "deployment.kubernetes.io/canary-paused"
+)
+
+// CanaryStep is a single batch in a canary rollout: grow the new RC to Replicas, then optionally
+// pause before advancing to the next step.
+type CanaryStep struct {
+	// Replicas is the new RC's desired replica count at this step, as an absolute number or a
+	// percentage of the deployment's total replicas.
+	Replicas intstr.IntOrString
+	// Pause halts the rollout after this step is reached. A nil Pause advances immediately.
+	Pause *CanaryPause
+}
+
+// CanaryPause describes how long a canary step waits before the rollout advances.
+type CanaryPause struct {
+	// Duration auto-advances the rollout after it elapses. A nil Duration pauses indefinitely,
+	// requiring CanaryPausedAnnotation to be set to "false" before the rollout proceeds.
+	Duration *time.Duration
+}
+
+// GetCanarySteps returns the ordered canary steps configured for the deployment, or nil if the
+// deployment carries no CanaryStepsAnnotation. Spec.Strategy.Type is not consulted.
+func GetCanarySteps(deployment extensions.Deployment) ([]CanaryStep, error) {
+	encoded, ok := deployment.Annotations[CanaryStepsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var steps []CanaryStep
+	if err := json.Unmarshal([]byte(encoded), &steps); err != nil {
+		return nil, fmt.Errorf("error decoding canary steps: %v", err)
+	}
+	return steps, nil
+}
+
+// SetCanarySteps encodes steps onto the deployment's CanaryStepsAnnotation.
+func SetCanarySteps(deployment *extensions.Deployment, steps []CanaryStep) error {
+	encoded, err := json.Marshal(steps)
+	if err != nil {
+		return fmt.Errorf("error encoding canary steps: %v", err)
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[CanaryStepsAnnotation] = string(encoded)
+	return nil
+}
+
+// CurrentCanaryStep returns the index of the step the rollout last advanced to, and the time it
+// did so. A deployment with no recorded step is at index -1 (the rollout hasn't grown the new RC
+// to any step yet).
+func CurrentCanaryStep(deployment extensions.Deployment) (int, time.Time, error) {
+	indexStr, ok := deployment.Annotations[CanaryStepIndexAnnotation]
+	if !ok {
+		return -1, time.Time{}, nil
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return -1, time.Time{}, fmt.Errorf("error decoding canary step index: %v", err)
+	}
+	transitionStr := deployment.Annotations[CanaryStepTransitionAnnotation]
+	if transitionStr == "" {
+		return index, time.Time{}, nil
+	}
+	transition, err := time.Parse(time.RFC3339, transitionStr)
+	if err != nil {
+		return -1, time.Time{}, fmt.Errorf("error decoding canary step transition time: %v", err)
+	}
+	return index, transition, nil
+}
+
+// SetCurrentCanaryStep persists the step the rollout just advanced to, along with the transition
+// time, so a controller restart resumes mid-rollout instead of starting over. It also re-arms
+// CanaryPausedAnnotation by clearing it, so a resume granted for the previous step doesn't leak
+// forward and auto-advance a later manual-pause step without approval.
+func SetCurrentCanaryStep(deployment *extensions.Deployment, index int, transitionTime time.Time) {
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[CanaryStepIndexAnnotation] = strconv.Itoa(index)
+	deployment.Annotations[CanaryStepTransitionAnnotation] = transitionTime.Format(time.RFC3339)
+	delete(deployment.Annotations, CanaryPausedAnnotation)
+}
+
+// IsCanaryStepPaused reports whether the rollout should hold at step. A step with no Pause never
+// blocks. A step with a Pause.Duration blocks until that much time has elapsed since
+// stepTransitionTime. A step with a nil Duration blocks until CanaryPausedAnnotation is present
+// and set to "false".
+func IsCanaryStepPaused(deployment extensions.Deployment, step CanaryStep, stepTransitionTime time.Time, now time.Time) bool {
+	if step.Pause == nil {
+		return false
+	}
+	if step.Pause.Duration != nil {
+		return now.Before(stepTransitionTime.Add(*step.Pause.Duration))
+	}
+	return deployment.Annotations[CanaryPausedAnnotation] != "false"
+}
+
+// NextCanaryRCSize returns the replica count the new RC should be grown to for the given step,
+// resolving Replicas against the deployment's total desired replica count.
+func NextCanaryRCSize(deployment extensions.Deployment, step CanaryStep) (int, error) {
+	total := deployment.Spec.Replicas
+	size, err := intstr.GetValueFromIntOrPercent(&step.Replicas, total, true)
+	if err != nil {
+		return 0, fmt.Errorf("error resolving canary step replica count: %v", err)
+	}
+	if size > total {
+		size = total
+	}
+	return size, nil
+}
+
+// CanaryAction is what a controller driving deployment's canary rollout should do right now. It
+// ties GetCanarySteps, CurrentCanaryStep, NextCanaryRCSize, GetAvailablePodsForRCs and
+// IsCanaryStepPaused together into the single decision a caller actually needs, instead of making
+// every caller re-chain those helpers itself.
+type CanaryAction struct {
+	// StepIndex is the step currently being rolled out.
+	StepIndex int
+	// Replicas is the replica count the new RC should be grown to for StepIndex. The caller scales
+	// the new RC to this value unconditionally; it doesn't change until StepIndex does.
+	Replicas int
+	// RecordTransition is true the first time the new RC reaches Replicas available pods for
+	// StepIndex. The caller must call SetCurrentCanaryStep(deployment, StepIndex, now) so the
+	// step's Pause clock starts from this moment rather than from whenever RolloutStatus next
+	// happens to be polled.
+	RecordTransition bool
+	// ReadyToAdvance is true once StepIndex's Pause (if any) has elapsed or been explicitly
+	// resumed. The caller must call SetCurrentCanaryStep(deployment, NextStepIndex, time.Time{}) to
+	// move on; the zero transition time leaves the next step's Pause clock unstarted until that
+	// step reports RecordTransition. Until then it should keep reconciling at Replicas.
+	ReadyToAdvance bool
+	// NextStepIndex is the step to record when ReadyToAdvance is true.
+	NextStepIndex int
+	// Done is true once every step has already been advanced through, meaning the rollout should
+	// proceed exactly as a non-canary deployment would.
+	Done bool
+}
+
+// NextCanaryAction returns the CanaryAction deployment's canary rollout should take right now. A
+// deployment with no CanaryStepsAnnotation (not a canary rollout) returns Done=true.
+func NextCanaryAction(deployment extensions.Deployment, c clientset.Interface, now time.Time) (CanaryAction, error) {
+	return NextCanaryActionFromLists(deployment, now,
+		func(namespace string, options api.ListOptions) (*api.PodList, error) {
+			return c.Core().Pods(namespace).List(options)
+		},
+		func(namespace string, options api.ListOptions) ([]api.ReplicationController, error) {
+			rcList, err := c.Core().ReplicationControllers(namespace).List(options)
+			return rcList.Items, err
+		})
+}
+
+// NextCanaryActionFromLists is the injectable-list variant of NextCanaryAction, mirroring
+// RolloutStatusFromLists, so the canary reconciliation decision can be driven in tests without a
+// live clientset.
+func NextCanaryActionFromLists(deployment extensions.Deployment, now time.Time, getPodList func(string, api.ListOptions) (*api.PodList, error), getRcList func(string, api.ListOptions) ([]api.ReplicationController, error)) (CanaryAction, error) {
+	steps, err := GetCanarySteps(deployment)
+	if err != nil {
+		return CanaryAction{}, err
+	}
+	if len(steps) == 0 {
+		return CanaryAction{Done: true}, nil
+	}
+	index, transition, err := CurrentCanaryStep(deployment)
+	if err != nil {
+		return CanaryAction{}, err
+	}
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(steps) {
+		return CanaryAction{StepIndex: index, Done: true}, nil
+	}
+	step := steps[index]
+
+	replicas, err := NextCanaryRCSize(deployment, step)
+	if err != nil {
+		return CanaryAction{}, err
+	}
+	action := CanaryAction{StepIndex: index, Replicas: replicas}
+
+	newRC, err := GetNewRCFromList(deployment, nil, getRcList)
+	if err != nil {
+		return CanaryAction{}, err
+	}
+	if newRC == nil || newRC.Spec.Replicas < replicas {
+		// Still growing the new RC toward this step's target.
+		return action, nil
+	}
+	gates, err := ReadinessGates(deployment)
+	if err != nil {
+		return CanaryAction{}, err
+	}
+	available, err := GetAvailablePodsForRCsFromList([]*api.ReplicationController{newRC}, deployment.Spec.MinReadySeconds, gates, getPodList)
+	if err != nil {
+		return CanaryAction{}, err
+	}
+	if available < replicas {
+		return action, nil
+	}
+
+	if transition.IsZero() {
+		// This is the first reconcile to see the step's target reached; the Pause clock starts now.
+		action.RecordTransition = true
+		return action, nil
+	}
+	if IsCanaryStepPaused(deployment, step, transition, now) {
+		return action, nil
+	}
+	action.ReadyToAdvance = true
+	action.NextStepIndex = index + 1
+	return action, nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func TestIsCanaryStepPausedDuration(t *testing.T) {
+	d := time.Minute
+	step := CanaryStep{Pause: &CanaryPause{Duration: &d}}
+	transition := time.Now()
+	deployment := extensions.Deployment{}
+
+	if !IsCanaryStepPaused(deployment, step, transition, transition.Add(30*time.Second)) {
+		t.Errorf("expected step to still be paused before its duration elapses")
+	}
+	if IsCanaryStepPaused(deployment, step, transition, transition.Add(2*time.Minute)) {
+		t.Errorf("expected step to resume once its duration elapses")
+	}
+}
+
+func TestIsCanaryStepPausedManual(t *testing.T) {
+	step := CanaryStep{Pause: &CanaryPause{}}
+	now := time.Now()
+
+	deployment := extensions.Deployment{}
+	if !IsCanaryStepPaused(deployment, step, now, now) {
+		t.Errorf("expected a manual-pause step with no resume annotation to be paused")
+	}
+
+	deployment.Annotations = map[string]string{CanaryPausedAnnotation: "false"}
+	if IsCanaryStepPaused(deployment, step, now, now) {
+		t.Errorf("expected canary-paused=false to resume a manual-pause step")
+	}
+}
+
+// TestSetCurrentCanaryStepRearmsPause reproduces the bug where resuming one manual-pause step
+// left CanaryPausedAnnotation=false in place for every later step, so later human-gated steps
+// auto-advanced without approval.
+func TestSetCurrentCanaryStepRearmsPause(t *testing.T) {
+	manualStep := CanaryStep{Pause: &CanaryPause{}}
+	deployment := &extensions.Deployment{
+		ObjectMeta: api.ObjectMeta{Annotations: map[string]string{CanaryPausedAnnotation: "false"}},
+	}
+
+	SetCurrentCanaryStep(deployment, 1, time.Now())
+
+	index, transition, err := CurrentCanaryStep(*deployment)
+	if err != nil {
+		t.Fatalf("CurrentCanaryStep() error = %v", err)
+	}
+	if index != 1 {
+		t.Fatalf("expected recorded step index 1, got %d", index)
+	}
+	if !IsCanaryStepPaused(*deployment, manualStep, transition, transition) {
+		t.Errorf("expected the resume granted for the previous step not to carry over: step should be paused again")
+	}
+}
+
+// withCanarySteps layers two identical canary steps (each growing straight to the deployment's full
+// desired replica count) onto a rolloutFixture deployment, so NextCanaryActionFromLists tests only
+// have to vary RC/pod counts and the recorded step index.
+func withCanarySteps(t *testing.T, deployment extensions.Deployment, pause *CanaryPause) extensions.Deployment {
+	t.Helper()
+	step := CanaryStep{Replicas: intstr.FromInt(deployment.Spec.Replicas), Pause: pause}
+	if err := SetCanarySteps(&deployment, []CanaryStep{step, step}); err != nil {
+		t.Fatalf("SetCanarySteps() error = %v", err)
+	}
+	return deployment
+}
+
+func TestNextCanaryActionNoSteps(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 2)
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if !action.Done {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want Done = true for a deployment with no canary steps", action)
+	}
+}
+
+func TestNextCanaryActionScalingUp(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 1, 1)
+	deployment = withCanarySteps(t, deployment, nil)
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if action.StepIndex != 0 || action.Replicas != 2 || action.RecordTransition || action.ReadyToAdvance || action.Done {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want {StepIndex:0 Replicas:2} while the RC is still below the step target", action)
+	}
+}
+
+func TestNextCanaryActionWaitingForAvailability(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 1)
+	deployment = withCanarySteps(t, deployment, nil)
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if action.RecordTransition || action.ReadyToAdvance || action.Done {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want no transition recorded while available pods are still below the step target", action)
+	}
+}
+
+func TestNextCanaryActionRecordsTransition(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 2)
+	deployment = withCanarySteps(t, deployment, &CanaryPause{})
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if !action.RecordTransition || action.ReadyToAdvance {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want RecordTransition = true the first reconcile the step target is reached", action)
+	}
+}
+
+func TestNextCanaryActionPausedManual(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 2)
+	deployment = withCanarySteps(t, deployment, &CanaryPause{})
+	SetCurrentCanaryStep(&deployment, 0, time.Now())
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if action.RecordTransition || action.ReadyToAdvance {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want the step held until CanaryPausedAnnotation is resumed", action)
+	}
+}
+
+func TestNextCanaryActionReadyToAdvance(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 2)
+	deployment = withCanarySteps(t, deployment, nil)
+	SetCurrentCanaryStep(&deployment, 0, time.Now().Add(-time.Hour))
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if !action.ReadyToAdvance || action.NextStepIndex != 1 {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want ReadyToAdvance = true, NextStepIndex = 1 once an unpaused step's target is reached", action)
+	}
+}
+
+func TestNextCanaryActionDone(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 2)
+	deployment = withCanarySteps(t, deployment, nil)
+	SetCurrentCanaryStep(&deployment, 2, time.Now())
+
+	action, err := NextCanaryActionFromLists(deployment, time.Now(), getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("NextCanaryActionFromLists() error = %v", err)
+	}
+	if !action.Done {
+		t.Errorf("NextCanaryActionFromLists() = %+v, want Done = true once every step has been advanced through", action)
+	}
+}
@@ -19,6 +19,7 @@ package deployment
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"k8s.io/kubernetes/pkg/api"
@@ -37,6 +38,20 @@ const (
 	RollbackRevisionNotFound  = "DeploymentRollbackRevisionNotFound"
 	RollbackTemplateUnchanged = "DeploymentRollbackTemplateUnchanged"
 	RollbackDone              = "DeploymentRollback"
+
+	// DeploymentNameLabel is applied by the deployment controller to every RC it creates, so RCs
+	// belonging to a deployment can be found with a single indexed List call instead of scanning
+	// every RC in the namespace. RCs that predate this label (or were adopted from elsewhere) are
+	// found via the selector-intersection fallback in GetOldRCsFromLists/GetNewRCFromList.
+	DeploymentNameLabel = "deployment.kubernetes.io/deployment-name"
+
+	// RCIndexMigratedAnnotation is set on a Deployment by LabelRCsWithDeploymentName once it has
+	// confirmed every RC it owns carries DeploymentNameLabel. Only then is it safe for
+	// GetOldRCsFromLists/GetNewRCFromList to trust the indexed label lookup on its own; a
+	// non-empty labeled result is not by itself proof that labeling is complete, since an
+	// in-progress migration (or a brand-new, not-yet-labeled RC) can leave some owned RCs
+	// unlabeled while others already carry the label.
+	RCIndexMigratedAnnotation = "deployment.kubernetes.io/rc-index-migrated"
 )
 
 // GetOldRCs returns the old RCs targeted by the given Deployment; get PodList and RCList from client interface.
@@ -64,12 +79,24 @@ func GetOldRCsFromLists(deployment extensions.Deployment, c clientset.Interface,
 		return nil, nil, fmt.Errorf("error listing pods: %v", err)
 	}
 	// 2. Find the corresponding RCs for pods in podList.
-	// TODO: Right now we list all RCs and then filter. We should add an API for this.
+	// Only trust the indexed DeploymentNameLabel lookup once RCIndexMigratedAnnotation confirms
+	// every RC this deployment owns has been labeled; a non-empty labeled result on its own
+	// doesn't mean the label has fully replaced the selector scan below (see
+	// RCIndexMigratedAnnotation), so an incomplete migration falls back to listing RCs by the
+	// deployment's selector instead of silently losing unlabeled RCs.
 	oldRCs := map[string]api.ReplicationController{}
 	allOldRCs := map[string]api.ReplicationController{}
-	rcList, err := getRcList(namespace, options)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error listing replication controllers: %v", err)
+	var rcList []api.ReplicationController
+	if deployment.Annotations[RCIndexMigratedAnnotation] == "true" {
+		rcList, err = GetRCsForDeploymentFromList(deployment, getRcList)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		rcList, err = getRcList(namespace, options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing replication controllers: %v", err)
+		}
 	}
 	newRCTemplate := GetNewRCTemplate(deployment)
 	for _, pod := range podList.Items {
@@ -113,9 +140,20 @@ func GetNewRC(deployment extensions.Deployment, c clientset.Interface) (*api.Rep
 // Returns nil if the new RC doesnt exist yet.
 func GetNewRCFromList(deployment extensions.Deployment, c clientset.Interface, getRcList func(string, api.ListOptions) ([]api.ReplicationController, error)) (*api.ReplicationController, error) {
 	namespace := deployment.ObjectMeta.Namespace
-	rcList, err := getRcList(namespace, api.ListOptions{LabelSelector: labels.SelectorFromSet(deployment.Spec.Selector)})
-	if err != nil {
-		return nil, fmt.Errorf("error listing replication controllers: %v", err)
+	// See the comment in GetOldRCsFromLists: only trust the indexed lookup once
+	// RCIndexMigratedAnnotation confirms every RC this deployment owns has been labeled.
+	var rcList []api.ReplicationController
+	var err error
+	if deployment.Annotations[RCIndexMigratedAnnotation] == "true" {
+		rcList, err = GetRCsForDeploymentFromList(deployment, getRcList)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rcList, err = getRcList(namespace, api.ListOptions{LabelSelector: labels.SelectorFromSet(deployment.Spec.Selector)})
+		if err != nil {
+			return nil, fmt.Errorf("error listing replication controllers: %v", err)
+		}
 	}
 	newRCTemplate := GetNewRCTemplate(deployment)
 
@@ -162,45 +200,172 @@ func GetReplicaCountForRCs(replicationControllers []*api.ReplicationController)
 	return totalReplicaCount
 }
 
-// Returns the number of available pods corresponding to the given RCs.
-func GetAvailablePodsForRCs(c clientset.Interface, rcs []*api.ReplicationController, minReadySeconds int) (int, error) {
-	allPods, err := getPodsForRCs(c, rcs)
+// Returns the number of available pods corresponding to the given RCs. A pod only counts as
+// available once every condition type in gates is True in addition to the built-in PodReady
+// condition; pass a nil gates to fall back to plain kubelet readiness.
+func GetAvailablePodsForRCs(c clientset.Interface, rcs []*api.ReplicationController, minReadySeconds int, gates []api.PodConditionType) (int, error) {
+	return GetAvailablePodsForRCsFromList(rcs, minReadySeconds, gates,
+		func(namespace string, options api.ListOptions) (*api.PodList, error) {
+			return c.Core().Pods(namespace).List(options)
+		})
+}
+
+// GetAvailablePodsForRCsFromList is the injectable-list variant of GetAvailablePodsForRCs, used
+// by callers (and tests) that already have a getPodList function, such as
+// GetOldRCsFromLists/RolloutStatusFromLists.
+func GetAvailablePodsForRCsFromList(rcs []*api.ReplicationController, minReadySeconds int, gates []api.PodConditionType, getPodList func(string, api.ListOptions) (*api.PodList, error)) (int, error) {
+	allPods, err := getPodsForRCsFromList(rcs, getPodList)
 	if err != nil {
 		return 0, err
 	}
-	return getReadyPodsCount(allPods, minReadySeconds), nil
+	return getReadyPodsCount(allPods, minReadySeconds, gates), nil
 }
 
-func getReadyPodsCount(pods []api.Pod, minReadySeconds int) int {
+func getReadyPodsCount(pods []api.Pod, minReadySeconds int, gates []api.PodConditionType) int {
 	readyPodCount := 0
-	for _, pod := range pods {
-		if api.IsPodReady(&pod) {
-			// Check if we've passed minReadySeconds since LastTransitionTime
-			// If so, this pod is ready
-			for _, c := range pod.Status.Conditions {
-				// we only care about pod ready conditions
-				if c.Type == api.PodReady {
-					// 2 cases that this ready condition is valid (passed minReadySeconds, i.e. the pod is ready):
-					// 1. minReadySeconds <= 0
-					// 2. LastTransitionTime (is set) + minReadySeconds (>0) < current time
-					minReadySecondsDuration := time.Duration(minReadySeconds) * time.Second
-					if minReadySeconds <= 0 || !c.LastTransitionTime.IsZero() && c.LastTransitionTime.Add(minReadySecondsDuration).Before(time.Now()) {
-						readyPodCount++
-						break
-					}
-				}
-			}
+	now := time.Now()
+	for i := range pods {
+		if IsPodAvailable(&pods[i], gates, minReadySeconds, now) {
+			readyPodCount++
 		}
 	}
 	return readyPodCount
 }
 
+// IsPodAvailable returns true once pod's built-in PodReady condition and every condition type in
+// gates is True, and the most recent of those conditions' LastTransitionTime is at least
+// minReadySeconds in the past. gates lets callers wait on external readiness signals (a load
+// balancer, a service mesh sidecar) before a rollout treats a pod as available, not just on
+// kubelet readiness.
+func IsPodAvailable(pod *api.Pod, gates []api.PodConditionType, minReadySeconds int, now time.Time) bool {
+	if !api.IsPodReady(pod) {
+		return false
+	}
+	conditions := map[api.PodConditionType]*api.PodCondition{}
+	for i := range pod.Status.Conditions {
+		c := &pod.Status.Conditions[i]
+		conditions[c.Type] = c
+	}
+	var lastTransition time.Time
+	for _, gate := range append([]api.PodConditionType{api.PodReady}, gates...) {
+		c, ok := conditions[gate]
+		if !ok || c.Status != api.ConditionTrue {
+			return false
+		}
+		if c.LastTransitionTime.Time.After(lastTransition) {
+			lastTransition = c.LastTransitionTime.Time
+		}
+	}
+	if minReadySeconds <= 0 {
+		return true
+	}
+	if lastTransition.IsZero() {
+		return false
+	}
+	return lastTransition.Add(time.Duration(minReadySeconds) * time.Second).Before(now)
+}
+
+// ValidateReadinessGates rejects a gate list that duplicates the built-in PodReady condition,
+// declares the same condition type more than once, or (when declared is non-nil) names a
+// condition type declared doesn't contain. declared is nil when the pod template carries no
+// PodTemplateReadinessGatesAnnotation, in which case that last check is skipped: this repo chunk
+// has no typed PodSpec.ReadinessGates field for ValidateReadinessGates to check a gate against, so
+// without a template declaration there is nothing trustworthy to validate gates against, and
+// ValidateReadinessGates falls back to only the structural checks above. Callers that do have a
+// declared set should always pass it, so a misspelled or never-set condition type is rejected here
+// instead of leaving a rollout waiting forever with no diagnostic.
+func ValidateReadinessGates(gates []api.PodConditionType, declared []api.PodConditionType) error {
+	var declaredSet map[api.PodConditionType]bool
+	if declared != nil {
+		declaredSet = make(map[api.PodConditionType]bool, len(declared))
+		for _, d := range declared {
+			declaredSet[d] = true
+		}
+	}
+	seen := map[api.PodConditionType]bool{}
+	for _, gate := range gates {
+		if gate == api.PodReady {
+			return fmt.Errorf("readiness gate %q duplicates the built-in pod-ready condition", gate)
+		}
+		if seen[gate] {
+			return fmt.Errorf("readiness gate %q is declared more than once", gate)
+		}
+		if declaredSet != nil && !declaredSet[gate] {
+			return fmt.Errorf("readiness gate %q is not declared by the pod template's %s annotation", gate, PodTemplateReadinessGatesAnnotation)
+		}
+		seen[gate] = true
+	}
+	return nil
+}
+
+const (
+	// ReadinessGatesAnnotation carries a comma-separated list of extra pod condition types that
+	// must be True, in addition to the built-in PodReady condition, before
+	// GetAvailablePodsForRCs/RolloutStatus count a pod as available. See ReadinessGates.
+	ReadinessGatesAnnotation = "deployment.kubernetes.io/readiness-gates"
+
+	// PodTemplateReadinessGatesAnnotation is set on a deployment's pod template (not the
+	// deployment itself) by whatever is responsible for eventually setting a gate's condition on
+	// pods built from it - e.g. a service mesh sidecar injector, or a cloud-provider admission
+	// controller. It declares the full set of extra condition types that injector promises to
+	// set, so ReadinessGates/ValidateReadinessGates can reject a ReadinessGatesAnnotation entry
+	// that no injector has promised to ever set, instead of silently accepting it and leaving the
+	// rollout waiting on a condition nothing will set.
+	PodTemplateReadinessGatesAnnotation = "deployment.kubernetes.io/template-readiness-gates"
+)
+
+// DeclaredReadinessGates returns the extra pod condition types template's
+// PodTemplateReadinessGatesAnnotation promises to eventually set, or nil if template carries no
+// such annotation (meaning no declaration is available to validate against).
+func DeclaredReadinessGates(template api.PodTemplateSpec) []api.PodConditionType {
+	v, ok := template.ObjectMeta.Annotations[PodTemplateReadinessGatesAnnotation]
+	if !ok {
+		return nil
+	}
+	if v == "" {
+		return []api.PodConditionType{}
+	}
+	parts := strings.Split(v, ",")
+	declared := make([]api.PodConditionType, 0, len(parts))
+	for _, part := range parts {
+		declared = append(declared, api.PodConditionType(strings.TrimSpace(part)))
+	}
+	return declared
+}
+
+// ReadinessGates returns the extra pod condition types configured on the deployment via
+// ReadinessGatesAnnotation, validated with ValidateReadinessGates against the pod template's
+// DeclaredReadinessGates. A deployment without the annotation returns a nil slice, meaning only
+// the built-in PodReady condition gates availability.
+func ReadinessGates(deployment extensions.Deployment) ([]api.PodConditionType, error) {
+	v := deployment.Annotations[ReadinessGatesAnnotation]
+	if v == "" {
+		return nil, nil
+	}
+	parts := strings.Split(v, ",")
+	gates := make([]api.PodConditionType, 0, len(parts))
+	for _, part := range parts {
+		gates = append(gates, api.PodConditionType(strings.TrimSpace(part)))
+	}
+	if err := ValidateReadinessGates(gates, DeclaredReadinessGates(deployment.Spec.Template)); err != nil {
+		return nil, err
+	}
+	return gates, nil
+}
+
 func getPodsForRCs(c clientset.Interface, replicationControllers []*api.ReplicationController) ([]api.Pod, error) {
+	return getPodsForRCsFromList(replicationControllers,
+		func(namespace string, options api.ListOptions) (*api.PodList, error) {
+			return c.Core().Pods(namespace).List(options)
+		})
+}
+
+func getPodsForRCsFromList(replicationControllers []*api.ReplicationController, getPodList func(string, api.ListOptions) (*api.PodList, error)) ([]api.Pod, error) {
 	allPods := []api.Pod{}
 	for _, rc := range replicationControllers {
 		selector := labels.SelectorFromSet(rc.Spec.Selector)
 		options := api.ListOptions{LabelSelector: selector}
-		podList, err := c.Core().Pods(rc.ObjectMeta.Namespace).List(options)
+		podList, err := getPodList(rc.ObjectMeta.Namespace, options)
 		if err != nil {
 			return allPods, fmt.Errorf("error listing pods: %v", err)
 		}
@@ -217,3 +382,79 @@ func Revision(rc *api.ReplicationController) (int64, error) {
 	}
 	return strconv.ParseInt(v, 10, 64)
 }
+
+// GetRCsForDeployment returns every RC labeled as belonging to the given deployment, using a
+// single indexed List call against DeploymentNameLabel.
+func GetRCsForDeployment(deployment extensions.Deployment, c clientset.Interface) ([]*api.ReplicationController, error) {
+	rcList, err := GetRCsForDeploymentFromList(deployment,
+		func(namespace string, options api.ListOptions) ([]api.ReplicationController, error) {
+			rcList, err := c.Core().ReplicationControllers(namespace).List(options)
+			return rcList.Items, err
+		})
+	if err != nil {
+		return nil, err
+	}
+	rcs := make([]*api.ReplicationController, 0, len(rcList))
+	for i := range rcList {
+		rcs = append(rcs, &rcList[i])
+	}
+	return rcs, nil
+}
+
+// GetRCsForDeploymentFromList returns every RC labeled as belonging to the given deployment,
+// using the input function to issue the indexed List call. When no RC carries the label it
+// returns whatever empty result the input function gives and no error. GetOldRCsFromLists/
+// GetNewRCFromList use it only once RCIndexMigratedAnnotation is set, and otherwise list RCs by
+// the deployment's selector.
+func GetRCsForDeploymentFromList(deployment extensions.Deployment, getRcList func(string, api.ListOptions) ([]api.ReplicationController, error)) ([]api.ReplicationController, error) {
+	namespace := deployment.ObjectMeta.Namespace
+	selector := labels.SelectorFromSet(labels.Set{DeploymentNameLabel: deployment.ObjectMeta.Name})
+	rcList, err := getRcList(namespace, api.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing replication controllers: %v", err)
+	}
+	return rcList, nil
+}
+
+// LabelRCsWithDeploymentName back-labels every RC belonging to the deployment that is missing
+// DeploymentNameLabel, so future lookups can use the indexed path in GetRCsForDeployment. It
+// matches RCs by walking GetOldRCs/GetNewRC (selector intersection plus pod-template hash),
+// since selector-based matching alone can't tell a deployment's own RCs from ones that merely
+// share its selector. deployment is always discovered via the full selector scan here (it can't
+// yet carry RCIndexMigratedAnnotation, or there would be nothing left to migrate), so this is the
+// one place that authoritatively knows every RC the deployment owns. Once every candidate carries
+// the label, it stamps RCIndexMigratedAnnotation on the deployment so GetOldRCsFromLists/
+// GetNewRCFromList can trust the indexed lookup on its own from then on.
+func LabelRCsWithDeploymentName(deployment *extensions.Deployment, c clientset.Interface) error {
+	_, allOldRCs, err := GetOldRCs(*deployment, c)
+	if err != nil {
+		return err
+	}
+	candidates := allOldRCs
+	newRC, err := GetNewRC(*deployment, c)
+	if err != nil {
+		return err
+	}
+	if newRC != nil {
+		candidates = append(candidates, newRC)
+	}
+	for _, rc := range candidates {
+		if _, ok := rc.Labels[DeploymentNameLabel]; ok {
+			continue
+		}
+		rcCopy := *rc
+		rcCopy.Labels = labelsutil.CloneAndAddLabel(rcCopy.Labels, DeploymentNameLabel, deployment.ObjectMeta.Name)
+		if _, err := c.Core().ReplicationControllers(rcCopy.Namespace).Update(&rcCopy); err != nil {
+			return fmt.Errorf("error labeling replication controller %q with deployment name: %v", rcCopy.Name, err)
+		}
+	}
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[RCIndexMigratedAnnotation] = "true"
+	if _, err := c.Extensions().Deployments(deployment.Namespace).Update(deployment); err != nil {
+		return fmt.Errorf("error marking deployment %q as RC-index migrated: %v", deployment.Name, err)
+	}
+	return nil
+}
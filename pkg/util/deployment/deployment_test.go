@@ -0,0 +1,201 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func condition(condType api.PodConditionType, status api.ConditionStatus, transition time.Time) api.PodCondition {
+	return api.PodCondition{
+		Type:               condType,
+		Status:             status,
+		LastTransitionTime: unversioned.Time{Time: transition},
+	}
+}
+
+func TestIsPodAvailable(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-time.Hour)
+	lbHealthy := api.PodConditionType("cloudprovider.com/lb-healthy")
+
+	tests := []struct {
+		name            string
+		conditions      []api.PodCondition
+		gates           []api.PodConditionType
+		minReadySeconds int
+		want            bool
+	}{
+		{
+			name:       "not kubelet ready",
+			conditions: []api.PodCondition{condition(api.PodReady, api.ConditionFalse, longAgo)},
+			want:       false,
+		},
+		{
+			name:       "kubelet ready, no gates, no minReadySeconds",
+			conditions: []api.PodCondition{condition(api.PodReady, api.ConditionTrue, longAgo)},
+			want:       true,
+		},
+		{
+			name:       "gate missing entirely",
+			conditions: []api.PodCondition{condition(api.PodReady, api.ConditionTrue, longAgo)},
+			gates:      []api.PodConditionType{lbHealthy},
+			want:       false,
+		},
+		{
+			name: "gate present but false",
+			conditions: []api.PodCondition{
+				condition(api.PodReady, api.ConditionTrue, longAgo),
+				condition(lbHealthy, api.ConditionFalse, longAgo),
+			},
+			gates: []api.PodConditionType{lbHealthy},
+			want:  false,
+		},
+		{
+			name: "gate transitions true, all conditions old enough",
+			conditions: []api.PodCondition{
+				condition(api.PodReady, api.ConditionTrue, longAgo),
+				condition(lbHealthy, api.ConditionTrue, longAgo),
+			},
+			gates:           []api.PodConditionType{lbHealthy},
+			minReadySeconds: 30,
+			want:            true,
+		},
+		{
+			name: "gate transitioned true too recently",
+			conditions: []api.PodCondition{
+				condition(api.PodReady, api.ConditionTrue, longAgo),
+				condition(lbHealthy, api.ConditionTrue, now),
+			},
+			gates:           []api.PodConditionType{lbHealthy},
+			minReadySeconds: 30,
+			want:            false,
+		},
+	}
+
+	for _, test := range tests {
+		pod := &api.Pod{Status: api.PodStatus{Conditions: test.conditions}}
+		got := IsPodAvailable(pod, test.gates, test.minReadySeconds, now)
+		if got != test.want {
+			t.Errorf("%s: IsPodAvailable() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidateReadinessGates(t *testing.T) {
+	lbHealthy := api.PodConditionType("cloudprovider.com/lb-healthy")
+	meshReady := api.PodConditionType("service-mesh.io/traffic-ready")
+	misspelled := api.PodConditionType("cloudprovider.com/lb-helathy")
+
+	tests := []struct {
+		name     string
+		gates    []api.PodConditionType
+		declared []api.PodConditionType
+		wantErr  bool
+	}{
+		{name: "no gates", gates: nil, wantErr: false},
+		{name: "distinct gates, no declaration to check against", gates: []api.PodConditionType{lbHealthy, meshReady}, wantErr: false},
+		{name: "duplicate gate", gates: []api.PodConditionType{lbHealthy, lbHealthy}, wantErr: true},
+		{name: "built-in PodReady redeclared", gates: []api.PodConditionType{api.PodReady}, wantErr: true},
+		{
+			name:     "gate matches template declaration",
+			gates:    []api.PodConditionType{lbHealthy},
+			declared: []api.PodConditionType{lbHealthy, meshReady},
+			wantErr:  false,
+		},
+		{
+			name:     "misspelled gate not declared by template",
+			gates:    []api.PodConditionType{misspelled},
+			declared: []api.PodConditionType{lbHealthy, meshReady},
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		err := ValidateReadinessGates(test.gates, test.declared)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: ValidateReadinessGates() error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestDeclaredReadinessGates(t *testing.T) {
+	template := api.PodTemplateSpec{}
+	if got := DeclaredReadinessGates(template); got != nil {
+		t.Errorf("DeclaredReadinessGates() = %v, want nil for a template with no declaration annotation", got)
+	}
+
+	template.ObjectMeta.Annotations = map[string]string{
+		PodTemplateReadinessGatesAnnotation: "cloudprovider.com/lb-healthy, service-mesh.io/traffic-ready",
+	}
+	want := []api.PodConditionType{"cloudprovider.com/lb-healthy", "service-mesh.io/traffic-ready"}
+	got := DeclaredReadinessGates(template)
+	if len(got) != len(want) {
+		t.Fatalf("DeclaredReadinessGates() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("DeclaredReadinessGates()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadinessGates(t *testing.T) {
+	deployment := extensions.Deployment{}
+	gates, err := ReadinessGates(deployment)
+	if err != nil {
+		t.Fatalf("ReadinessGates() error = %v", err)
+	}
+	if len(gates) != 0 {
+		t.Errorf("expected no gates for a deployment without %s, got %v", ReadinessGatesAnnotation, gates)
+	}
+
+	deployment.Annotations = map[string]string{
+		ReadinessGatesAnnotation: "cloudprovider.com/lb-healthy, service-mesh.io/traffic-ready",
+	}
+	gates, err = ReadinessGates(deployment)
+	if err != nil {
+		t.Fatalf("ReadinessGates() error = %v", err)
+	}
+	want := []api.PodConditionType{"cloudprovider.com/lb-healthy", "service-mesh.io/traffic-ready"}
+	if len(gates) != len(want) {
+		t.Fatalf("ReadinessGates() = %v, want %v", gates, want)
+	}
+	for i := range want {
+		if gates[i] != want[i] {
+			t.Errorf("ReadinessGates()[%d] = %q, want %q", i, gates[i], want[i])
+		}
+	}
+
+	deployment.Annotations[ReadinessGatesAnnotation] = string(api.PodReady)
+	if _, err := ReadinessGates(deployment); err == nil {
+		t.Errorf("expected ReadinessGates() to reject a gate duplicating the built-in PodReady condition")
+	}
+
+	deployment.Annotations[ReadinessGatesAnnotation] = "cloudprovider.com/lb-healthy"
+	deployment.Spec.Template.ObjectMeta.Annotations = map[string]string{
+		PodTemplateReadinessGatesAnnotation: "service-mesh.io/traffic-ready",
+	}
+	if _, err := ReadinessGates(deployment); err == nil {
+		t.Errorf("expected ReadinessGates() to reject a gate the pod template doesn't declare")
+	}
+}
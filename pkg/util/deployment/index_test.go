@@ -0,0 +1,109 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+func newTestDeployment(selector map[string]string) extensions.Deployment {
+	return extensions.Deployment{
+		ObjectMeta: api.ObjectMeta{Name: "foo", Namespace: "default"},
+		Spec: extensions.DeploymentSpec{
+			Replicas: 1,
+			Selector: selector,
+			Template: api.PodTemplateSpec{
+				ObjectMeta: api.ObjectMeta{Labels: selector},
+			},
+		},
+	}
+}
+
+// TestGetNewRCFromListUnmigratedFallsBackToSelectorScan reproduces the bug where a non-empty but
+// partial DeploymentNameLabel result (e.g. a migration in progress, or a brand-new unlabeled RC)
+// was trusted outright, making the new RC invisible to GetNewRCFromList.
+func TestGetNewRCFromListUnmigratedFallsBackToSelectorScan(t *testing.T) {
+	selector := map[string]string{"app": "foo"}
+	deployment := newTestDeployment(selector)
+	newRCTemplate := GetNewRCTemplate(deployment)
+
+	labeledRC := api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "old", Labels: map[string]string{DeploymentNameLabel: "foo"}},
+		Spec:       api.ReplicationControllerSpec{Selector: selector},
+	}
+	// The new RC exists but hasn't been labeled yet - e.g. it was just created by the
+	// controller and the migration step hasn't caught up with it.
+	unlabeledNewRC := api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "new"},
+		Spec:       api.ReplicationControllerSpec{Selector: selector, Template: &newRCTemplate},
+	}
+
+	getRcList := func(namespace string, options api.ListOptions) ([]api.ReplicationController, error) {
+		if options.LabelSelector.Matches(labels.Set{DeploymentNameLabel: "foo"}) {
+			return []api.ReplicationController{labeledRC}, nil
+		}
+		return []api.ReplicationController{labeledRC, unlabeledNewRC}, nil
+	}
+
+	// Without RCIndexMigratedAnnotation, the deployment is known to be partially migrated, so
+	// GetNewRCFromList must fall back to the full selector scan and still find the new RC.
+	rc, err := GetNewRCFromList(deployment, nil, getRcList)
+	if err != nil {
+		t.Fatalf("GetNewRCFromList() error = %v", err)
+	}
+	if rc == nil || rc.Name != "new" {
+		t.Fatalf("expected GetNewRCFromList() to find the unlabeled new RC via the selector-scan fallback, got %v", rc)
+	}
+}
+
+// TestGetNewRCFromListMigratedTrustsIndex verifies that once RCIndexMigratedAnnotation is set,
+// GetNewRCFromList trusts the indexed lookup without falling back to a full scan.
+func TestGetNewRCFromListMigratedTrustsIndex(t *testing.T) {
+	selector := map[string]string{"app": "foo"}
+	deployment := newTestDeployment(selector)
+	deployment.Annotations = map[string]string{RCIndexMigratedAnnotation: "true"}
+	newRCTemplate := GetNewRCTemplate(deployment)
+
+	labeledNewRC := api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "new", Labels: map[string]string{DeploymentNameLabel: "foo"}},
+		Spec:       api.ReplicationControllerSpec{Selector: selector, Template: &newRCTemplate},
+	}
+
+	calledFullScan := false
+	getRcList := func(namespace string, options api.ListOptions) ([]api.ReplicationController, error) {
+		if options.LabelSelector.Matches(labels.Set{DeploymentNameLabel: "foo"}) {
+			return []api.ReplicationController{labeledNewRC}, nil
+		}
+		calledFullScan = true
+		return []api.ReplicationController{labeledNewRC}, nil
+	}
+
+	rc, err := GetNewRCFromList(deployment, nil, getRcList)
+	if err != nil {
+		t.Fatalf("GetNewRCFromList() error = %v", err)
+	}
+	if rc == nil || rc.Name != "new" {
+		t.Fatalf("expected GetNewRCFromList() to find the new RC via the index, got %v", rc)
+	}
+	if calledFullScan {
+		t.Errorf("expected a migrated deployment not to fall back to the full selector scan")
+	}
+}
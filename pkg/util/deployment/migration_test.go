@@ -0,0 +1,120 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	fake "k8s.io/kubernetes/pkg/client/clientset_generated/release_1_2/fake"
+	clientsettesting "k8s.io/kubernetes/pkg/client/testing/core"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// migrationFixture returns an unmigrated deployment with one matching pod (so GetOldRCs has
+// something to key its old-RC scan off of), two unlabeled old RCs, an already-labeled old RC, and
+// an unlabeled new RC - the shape LabelRCsWithDeploymentName is meant to back-label.
+func migrationFixture() (extensions.Deployment, *api.Pod, *api.ReplicationController, *api.ReplicationController, *api.ReplicationController, *api.ReplicationController) {
+	selector := map[string]string{"app": "foo"}
+	deployment := extensions.Deployment{
+		ObjectMeta: api.ObjectMeta{Name: "foo", Namespace: "default"},
+		Spec: extensions.DeploymentSpec{
+			Replicas: 1,
+			Selector: selector,
+			Template: api.PodTemplateSpec{
+				ObjectMeta: api.ObjectMeta{Labels: selector},
+				Spec:       api.PodSpec{Containers: []api.Container{{Name: "app", Image: "current"}}},
+			},
+		},
+	}
+	newRCTemplate := GetNewRCTemplate(deployment)
+
+	pod := &api.Pod{ObjectMeta: api.ObjectMeta{Name: "pod", Namespace: "default", Labels: selector}}
+
+	oldTemplate := api.PodTemplateSpec{
+		ObjectMeta: api.ObjectMeta{Labels: selector},
+		Spec:       api.PodSpec{Containers: []api.Container{{Name: "app", Image: "v1"}}},
+	}
+	unlabeledOldA := &api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "old-a", Namespace: "default"},
+		Spec:       api.ReplicationControllerSpec{Selector: selector, Template: &oldTemplate},
+	}
+	unlabeledOldB := &api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "old-b", Namespace: "default"},
+		Spec:       api.ReplicationControllerSpec{Selector: selector, Template: &oldTemplate},
+	}
+	alreadyLabeledOld := &api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "old-c", Namespace: "default", Labels: map[string]string{DeploymentNameLabel: "foo"}},
+		Spec:       api.ReplicationControllerSpec{Selector: selector, Template: &oldTemplate},
+	}
+	unlabeledNew := &api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "new", Namespace: "default"},
+		Spec:       api.ReplicationControllerSpec{Selector: selector, Template: &newRCTemplate},
+	}
+	return deployment, pod, unlabeledOldA, unlabeledOldB, alreadyLabeledOld, unlabeledNew
+}
+
+func TestLabelRCsWithDeploymentName(t *testing.T) {
+	deployment, pod, oldA, oldB, oldC, newRC := migrationFixture()
+	client := fake.NewSimpleClientset(pod, oldA, oldB, oldC, newRC, &deployment)
+
+	if err := LabelRCsWithDeploymentName(&deployment, client); err != nil {
+		t.Fatalf("LabelRCsWithDeploymentName() error = %v", err)
+	}
+
+	if deployment.Annotations[RCIndexMigratedAnnotation] != "true" {
+		t.Errorf("expected %s to be set to \"true\" once every candidate RC is labeled", RCIndexMigratedAnnotation)
+	}
+	for _, name := range []string{"old-a", "old-b", "old-c", "new"} {
+		got, err := client.Core().ReplicationControllers("default").Get(name)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", name, err)
+		}
+		if got.Labels[DeploymentNameLabel] != "foo" {
+			t.Errorf("RC %q labels = %v, want %s=foo", name, got.Labels, DeploymentNameLabel)
+		}
+	}
+}
+
+// TestLabelRCsWithDeploymentNamePartialFailure makes one candidate's Update fail, and verifies
+// LabelRCsWithDeploymentName stops and reports the error instead of stamping
+// RCIndexMigratedAnnotation - a migration that didn't actually reach every owned RC must not make
+// GetOldRCsFromLists/GetNewRCFromList start trusting the indexed lookup on its own.
+func TestLabelRCsWithDeploymentNamePartialFailure(t *testing.T) {
+	deployment, pod, oldA, oldB, oldC, newRC := migrationFixture()
+	client := fake.NewSimpleClientset(pod, oldA, oldB, oldC, newRC, &deployment)
+	client.PrependReactor("update", "replicationcontrollers", func(action clientsettesting.Action) (bool, runtime.Object, error) {
+		update, ok := action.(clientsettesting.UpdateAction)
+		if !ok {
+			return false, nil, nil
+		}
+		rc := update.GetObject().(*api.ReplicationController)
+		if rc.Name == "old-b" {
+			return true, nil, fmt.Errorf("simulated update failure")
+		}
+		return false, nil, nil
+	})
+
+	if err := LabelRCsWithDeploymentName(&deployment, client); err == nil {
+		t.Fatal("LabelRCsWithDeploymentName() error = nil, want an error from the failed update")
+	}
+	if _, ok := deployment.Annotations[RCIndexMigratedAnnotation]; ok {
+		t.Errorf("expected %s not to be set after a partial-labeling failure", RCIndexMigratedAnnotation)
+	}
+}
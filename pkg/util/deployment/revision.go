@@ -0,0 +1,217 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/release_1_2"
+)
+
+const (
+	// ChangeCauseAnnotation records why a revision was created. ListRevisions/GetRevision
+	// surface it as RevisionInfo.ChangeCause, and `kubectl rollout history` prints it.
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+
+	// lastAppliedConfigAnnotation is kubectl's record of an object's last applied
+	// configuration. RollbackToRevision reads a change cause out of it when the caller doesn't
+	// supply one explicitly.
+	lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// RevisionInfo is one entry in a deployment's revision history: the RC that recorded the
+// revision, the pod template it rolled out, and when and why.
+type RevisionInfo struct {
+	Revision    int64
+	RC          *api.ReplicationController
+	Template    api.PodTemplateSpec
+	CreatedAt   unversioned.Time
+	ChangeCause string
+}
+
+type revisionInfoByRevision []RevisionInfo
+
+func (r revisionInfoByRevision) Len() int           { return len(r) }
+func (r revisionInfoByRevision) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r revisionInfoByRevision) Less(i, j int) bool { return r[i].Revision < r[j].Revision }
+
+// ListRevisions returns every RC the deployment owns that records a revision, sorted ascending
+// by revision number. A deployment carrying RCIndexMigratedAnnotation has its RCs listed with
+// GetRCsForDeployment. Otherwise they come from GetOldRCs/GetNewRC, and GetOldRCs reports old
+// RCs only while at least one pod matching the deployment's selector exists.
+func ListRevisions(deployment extensions.Deployment, c clientset.Interface) ([]RevisionInfo, error) {
+	var rcs []*api.ReplicationController
+	if deployment.Annotations[RCIndexMigratedAnnotation] == "true" {
+		labeled, err := GetRCsForDeployment(deployment, c)
+		if err != nil {
+			return nil, err
+		}
+		rcs = labeled
+	} else {
+		_, allOldRCs, err := GetOldRCs(deployment, c)
+		if err != nil {
+			return nil, err
+		}
+		rcs = allOldRCs
+		newRC, err := GetNewRC(deployment, c)
+		if err != nil {
+			return nil, err
+		}
+		if newRC != nil {
+			rcs = append(rcs, newRC)
+		}
+	}
+
+	revisions := make([]RevisionInfo, 0, len(rcs))
+	for _, rc := range rcs {
+		if _, ok := rc.Annotations[RevisionAnnotation]; !ok {
+			continue
+		}
+		revision, err := Revision(rc)
+		if err != nil {
+			return nil, fmt.Errorf("error reading revision of replication controller %q: %v", rc.Name, err)
+		}
+		template := rc.Spec.Template
+		revisions = append(revisions, RevisionInfo{
+			Revision:    revision,
+			RC:          rc,
+			Template:    *template,
+			CreatedAt:   rc.CreationTimestamp,
+			ChangeCause: rc.Annotations[ChangeCauseAnnotation],
+		})
+	}
+	sort.Sort(revisionInfoByRevision(revisions))
+	return revisions, nil
+}
+
+// GetRevision returns the RevisionInfo for a single revision of the deployment.
+func GetRevision(deployment extensions.Deployment, c clientset.Interface, revision int64) (RevisionInfo, error) {
+	revisions, err := ListRevisions(deployment, c)
+	if err != nil {
+		return RevisionInfo{}, err
+	}
+	for _, r := range revisions {
+		if r.Revision == revision {
+			return r, nil
+		}
+	}
+	return RevisionInfo{}, fmt.Errorf("unable to find a replication controller for revision %d of deployment %q", revision, deployment.Name)
+}
+
+// RollbackError is returned by RollbackToRevision when the target revision can't be found or the
+// rollback would be a no-op. Reason is one of RollbackRevisionNotFound or
+// RollbackTemplateUnchanged, so a caller recording a Kubernetes Event for the failure can use it
+// directly as the Event reason instead of having to string-match Error().
+type RollbackError struct {
+	Reason string
+	msg    string
+}
+
+func (e *RollbackError) Error() string { return e.msg }
+
+// RollbackToRevision points deployment's template back at the given revision's RC template. The
+// rollback itself becomes a new revision: the revision annotation is bumped past the current
+// max, and changeCause (or, if empty, the target RC's last-applied-configuration annotation) is
+// recorded as the change cause. When dryRun is true, deployment is mutated in memory but never
+// persisted to the API server. On success, the caller should record RollbackDone as the Event
+// reason. When the revision isn't found or matches the current template, err is a *RollbackError
+// whose Reason is RollbackRevisionNotFound or RollbackTemplateUnchanged; a failure to list the
+// deployment's RCs afterwards or to update the deployment returns a plain error.
+func RollbackToRevision(deployment *extensions.Deployment, c clientset.Interface, revision int64, changeCause string, dryRun bool) (*extensions.Deployment, error) {
+	target, err := GetRevision(*deployment, c, revision)
+	if err != nil {
+		return nil, &RollbackError{
+			Reason: RollbackRevisionNotFound,
+			msg:    fmt.Sprintf("unable to find the revision to rollback to: %v", err),
+		}
+	}
+	newTemplate := GetNewRCTemplate(*deployment)
+	if api.Semantic.DeepEqual(&target.Template, &newTemplate) {
+		return nil, &RollbackError{
+			Reason: RollbackTemplateUnchanged,
+			msg:    fmt.Sprintf("rolling back to revision %d is a no-op: it matches the deployment's current template", revision),
+		}
+	}
+	SetFromRCTemplate(deployment, target.Template)
+
+	revisions, err := ListRevisions(*deployment, c)
+	if err != nil {
+		return nil, err
+	}
+	var maxRevision int64
+	for _, r := range revisions {
+		if r.Revision > maxRevision {
+			maxRevision = r.Revision
+		}
+	}
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[RevisionAnnotation] = strconv.FormatInt(maxRevision+1, 10)
+
+	if changeCause == "" {
+		changeCause = target.RC.Annotations[lastAppliedConfigAnnotation]
+	}
+	if changeCause != "" {
+		deployment.Annotations[ChangeCauseAnnotation] = changeCause
+	}
+
+	if dryRun {
+		return deployment, nil
+	}
+	updated, err := c.Extensions().Deployments(deployment.Namespace).Update(deployment)
+	if err != nil {
+		return nil, fmt.Errorf("error updating deployment %q for rollback to revision %d: %v", deployment.Name, revision, err)
+	}
+	return updated, nil
+}
+
+// PruneRevisionHistory deletes RCs with zero replicas once the deployment's revision history
+// grows past limit, oldest first, always preserving the RC for keepRevision (typically the
+// target of a pending or just-completed rollback). A nil limit (mirroring an unset
+// Spec.RevisionHistoryLimit) means unlimited history: nothing is pruned.
+func PruneRevisionHistory(deployment extensions.Deployment, c clientset.Interface, limit *int32, keepRevision int64) error {
+	if limit == nil {
+		return nil
+	}
+	revisions, err := ListRevisions(deployment, c)
+	if err != nil {
+		return err
+	}
+	prunable := make([]RevisionInfo, 0, len(revisions))
+	for _, r := range revisions {
+		if r.Revision == keepRevision || r.RC.Spec.Replicas != 0 {
+			continue
+		}
+		prunable = append(prunable, r)
+	}
+	if int32(len(prunable)) <= *limit {
+		return nil
+	}
+	sort.Sort(revisionInfoByRevision(prunable))
+	for _, r := range prunable[:len(prunable)-int(*limit)] {
+		if err := c.Core().ReplicationControllers(r.RC.Namespace).Delete(r.RC.Name, nil); err != nil {
+			return fmt.Errorf("error pruning replication controller %q: %v", r.RC.Name, err)
+		}
+	}
+	return nil
+}
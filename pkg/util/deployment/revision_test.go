@@ -0,0 +1,214 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	fake "k8s.io/kubernetes/pkg/client/clientset_generated/release_1_2/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+func TestRevisionInfoByRevisionSort(t *testing.T) {
+	revisions := []RevisionInfo{
+		{Revision: 3},
+		{Revision: 1},
+		{Revision: 2},
+	}
+	sort.Sort(revisionInfoByRevision(revisions))
+	for i, want := range []int64{1, 2, 3} {
+		if revisions[i].Revision != want {
+			t.Errorf("revisions[%d].Revision = %d, want %d", i, revisions[i].Revision, want)
+		}
+	}
+}
+
+// TestPruneRevisionHistoryNilLimit guards against the zero-value-int32 footgun: a caller with no
+// configured RevisionHistoryLimit must pass a nil limit and see PruneRevisionHistory short-circuit
+// before ever listing or deleting anything, rather than having a bare 0 prune everything
+// prunable. It passes a nil clientset to prove ListRevisions is never reached.
+func TestPruneRevisionHistoryNilLimit(t *testing.T) {
+	if err := PruneRevisionHistory(extensions.Deployment{}, nil, nil, 0); err != nil {
+		t.Fatalf("PruneRevisionHistory() with a nil limit should be a no-op, got error = %v", err)
+	}
+}
+
+// newRevisionTestDeployment returns a deployment whose template is distinct from any revisionRC built
+// below, so GetNewRC/GetOldRCs and RollbackToRevision's no-op check can tell the deployment's
+// "live" template apart from its recorded revisions.
+func newRevisionTestDeployment(name string, replicas int) extensions.Deployment {
+	selector := map[string]string{"app": name}
+	return extensions.Deployment{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{RCIndexMigratedAnnotation: "true"}},
+		Spec: extensions.DeploymentSpec{
+			Replicas: replicas,
+			Selector: selector,
+			Template: api.PodTemplateSpec{
+				ObjectMeta: api.ObjectMeta{Labels: selector},
+				Spec:       api.PodSpec{Containers: []api.Container{{Name: "app", Image: "current"}}},
+			},
+		},
+	}
+}
+
+// revisionRC builds an RC recording revision of deploymentName, labeled for the
+// RCIndexMigratedAnnotation lookup path so ListRevisions/GetNewRC/GetOldRCs find it with a single
+// indexed List call, exactly as they would against a real cluster.
+func revisionRC(name, namespace, deploymentName string, revision int64, template api.PodTemplateSpec, replicas int) *api.ReplicationController {
+	return &api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{DeploymentNameLabel: deploymentName},
+			Annotations: map[string]string{RevisionAnnotation: strconv.FormatInt(revision, 10)},
+		},
+		Spec: api.ReplicationControllerSpec{Replicas: replicas, Template: &template},
+	}
+}
+
+func TestListRevisionsAndGetRevision(t *testing.T) {
+	deployment := newRevisionTestDeployment("foo", 2)
+	newTemplate := GetNewRCTemplate(deployment)
+	oldTemplate := api.PodTemplateSpec{
+		ObjectMeta: api.ObjectMeta{Labels: deployment.Spec.Selector},
+		Spec:       api.PodSpec{Containers: []api.Container{{Name: "app", Image: "v1"}}},
+	}
+	oldRC := revisionRC("foo-v1", deployment.Namespace, deployment.Name, 1, oldTemplate, 0)
+	newRC := revisionRC("foo-v2", deployment.Namespace, deployment.Name, 2, newTemplate, 2)
+	client := fake.NewSimpleClientset(oldRC, newRC)
+
+	revisions, err := ListRevisions(deployment, client)
+	if err != nil {
+		t.Fatalf("ListRevisions() error = %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 1 || revisions[1].Revision != 2 {
+		t.Fatalf("ListRevisions() = %+v, want revisions 1 then 2", revisions)
+	}
+
+	got, err := GetRevision(deployment, client, 1)
+	if err != nil {
+		t.Fatalf("GetRevision() error = %v", err)
+	}
+	if !api.Semantic.DeepEqual(got.Template, oldTemplate) {
+		t.Errorf("GetRevision(1).Template = %+v, want %+v", got.Template, oldTemplate)
+	}
+
+	if _, err := GetRevision(deployment, client, 99); err == nil {
+		t.Error("GetRevision() error = nil, want an error for an unrecorded revision")
+	}
+}
+
+func TestRollbackToRevision(t *testing.T) {
+	deployment := newRevisionTestDeployment("foo", 2)
+	newTemplate := GetNewRCTemplate(deployment)
+	oldTemplate := api.PodTemplateSpec{
+		ObjectMeta: api.ObjectMeta{Labels: deployment.Spec.Selector},
+		Spec:       api.PodSpec{Containers: []api.Container{{Name: "app", Image: "v1"}}},
+	}
+	oldRC := revisionRC("foo-v1", deployment.Namespace, deployment.Name, 1, oldTemplate, 0)
+	newRC := revisionRC("foo-v2", deployment.Namespace, deployment.Name, 2, newTemplate, 2)
+	client := fake.NewSimpleClientset(oldRC, newRC, &deployment)
+
+	updated, err := RollbackToRevision(&deployment, client, 1, "", false)
+	if err != nil {
+		t.Fatalf("RollbackToRevision() error = %v", err)
+	}
+	if !api.Semantic.DeepEqual(updated.Spec.Template.Spec, oldTemplate.Spec) {
+		t.Errorf("RollbackToRevision() template = %+v, want %+v", updated.Spec.Template.Spec, oldTemplate.Spec)
+	}
+	if updated.Annotations[RevisionAnnotation] != "3" {
+		t.Errorf("RollbackToRevision() revision annotation = %s, want 3 (past the current max of 2)", updated.Annotations[RevisionAnnotation])
+	}
+}
+
+func TestRollbackToRevisionNoop(t *testing.T) {
+	deployment := newRevisionTestDeployment("foo", 2)
+	newTemplate := GetNewRCTemplate(deployment)
+	newRC := revisionRC("foo-v2", deployment.Namespace, deployment.Name, 2, newTemplate, 2)
+	client := fake.NewSimpleClientset(newRC, &deployment)
+
+	_, err := RollbackToRevision(&deployment, client, 2, "", false)
+	if err == nil {
+		t.Fatal("RollbackToRevision() error = nil, want an error for a no-op rollback")
+	}
+	rerr, ok := err.(*RollbackError)
+	if !ok {
+		t.Fatalf("RollbackToRevision() error type = %T, want *RollbackError", err)
+	}
+	if rerr.Reason != RollbackTemplateUnchanged {
+		t.Errorf("RollbackToRevision() error reason = %s, want %s", rerr.Reason, RollbackTemplateUnchanged)
+	}
+}
+
+func TestRollbackToRevisionNotFound(t *testing.T) {
+	deployment := newRevisionTestDeployment("foo", 2)
+	newTemplate := GetNewRCTemplate(deployment)
+	newRC := revisionRC("foo-v2", deployment.Namespace, deployment.Name, 2, newTemplate, 2)
+	client := fake.NewSimpleClientset(newRC, &deployment)
+
+	_, err := RollbackToRevision(&deployment, client, 99, "", false)
+	if err == nil {
+		t.Fatal("RollbackToRevision() error = nil, want an error for an unrecorded revision")
+	}
+	rerr, ok := err.(*RollbackError)
+	if !ok {
+		t.Fatalf("RollbackToRevision() error type = %T, want *RollbackError", err)
+	}
+	if rerr.Reason != RollbackRevisionNotFound {
+		t.Errorf("RollbackToRevision() error reason = %s, want %s", rerr.Reason, RollbackRevisionNotFound)
+	}
+}
+
+func TestPruneRevisionHistoryDeletesOldestFirst(t *testing.T) {
+	deployment := newRevisionTestDeployment("foo", 2)
+	newTemplate := GetNewRCTemplate(deployment)
+	newRC := revisionRC("foo-v5", deployment.Namespace, deployment.Name, 5, newTemplate, 2)
+
+	var oldRCs []*api.ReplicationController
+	for i := int64(1); i <= 4; i++ {
+		template := api.PodTemplateSpec{
+			ObjectMeta: api.ObjectMeta{Labels: deployment.Spec.Selector},
+			Spec:       api.PodSpec{Containers: []api.Container{{Name: "app", Image: strconv.FormatInt(i, 10)}}},
+		}
+		oldRCs = append(oldRCs, revisionRC("foo-v"+strconv.FormatInt(i, 10), deployment.Namespace, deployment.Name, i, template, 0))
+	}
+	objs := []runtime.Object{newRC}
+	for _, rc := range oldRCs {
+		objs = append(objs, rc)
+	}
+	client := fake.NewSimpleClientset(objs...)
+
+	limit := int32(2)
+	if err := PruneRevisionHistory(deployment, client, &limit, 0); err != nil {
+		t.Fatalf("PruneRevisionHistory() error = %v", err)
+	}
+
+	for i, name := range []string{"foo-v1", "foo-v2"} {
+		if _, err := client.Core().ReplicationControllers(deployment.Namespace).Get(name); err == nil {
+			t.Errorf("expected %s (revision %d, oldest beyond the limit) to have been pruned", name, i+1)
+		}
+	}
+	for _, name := range []string{"foo-v3", "foo-v4", "foo-v5"} {
+		if _, err := client.Core().ReplicationControllers(deployment.Namespace).Get(name); err != nil {
+			t.Errorf("expected %s to survive pruning, got error = %v", name, err)
+		}
+	}
+}
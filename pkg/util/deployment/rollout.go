@@ -0,0 +1,214 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/release_1_2"
+	"k8s.io/kubernetes/pkg/util/wait"
+)
+
+// RolloutStatusType is the coarse-grained state of a deployment's rollout.
+type RolloutStatusType string
+
+const (
+	// RolloutProgressing means the new RC is still being scaled up, or old RCs are still being
+	// scaled down, and progress has been observed within the progress deadline.
+	RolloutProgressing RolloutStatusType = "Progressing"
+	// RolloutComplete means the new RC has reached the deployment's desired replica count, all
+	// of those replicas are available, and no old RC still has replicas.
+	RolloutComplete RolloutStatusType = "Complete"
+	// RolloutStalled means no progress has been observed for longer than the progress deadline.
+	RolloutStalled RolloutStatusType = "Stalled"
+	// RolloutPaused means the rollout is deliberately holding, e.g. at a canary step pause.
+	RolloutPaused RolloutStatusType = "Paused"
+)
+
+const (
+	// ProgressLastUpdateAnnotation records the last time the controller observed forward
+	// progress on the rollout (a new available pod, or a scaled-down old RC). RolloutStatus
+	// compares this timestamp against the progress deadline to detect a stalled rollout.
+	ProgressLastUpdateAnnotation = "deployment.kubernetes.io/progress-last-update-time"
+
+	// ProgressDeadlineSecondsAnnotation optionally overrides DefaultProgressDeadlineSeconds for
+	// a single deployment.
+	ProgressDeadlineSecondsAnnotation = "deployment.kubernetes.io/progress-deadline-seconds"
+
+	// DefaultProgressDeadlineSeconds is how long RolloutStatus waits for progress before
+	// reporting RolloutStalled, for deployments that don't set
+	// ProgressDeadlineSecondsAnnotation.
+	DefaultProgressDeadlineSeconds = 600
+)
+
+// DeploymentStatus is a structured, human-readable summary of a deployment's rollout progress,
+// computed from the RCs and pods GetNewRC/GetOldRCs/GetAvailablePodsForRCs already know how to
+// find. It is the machine-readable equivalent of `kubectl rollout status`.
+type DeploymentStatus struct {
+	Status RolloutStatusType
+	Reason string
+}
+
+// progressDeadline returns the deployment's configured progress deadline, in seconds.
+func progressDeadline(deployment extensions.Deployment) (int, error) {
+	v, ok := deployment.Annotations[ProgressDeadlineSecondsAnnotation]
+	if !ok {
+		return DefaultProgressDeadlineSeconds, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding progress deadline: %v", err)
+	}
+	return seconds, nil
+}
+
+// lastProgressTime returns the last time forward progress on the rollout was recorded with
+// SetLastProgressTime. A deployment that has never recorded progress returns the zero time.
+func lastProgressTime(deployment extensions.Deployment) (time.Time, error) {
+	v, ok := deployment.Annotations[ProgressLastUpdateAnnotation]
+	if !ok {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error decoding progress last-update time: %v", err)
+	}
+	return t, nil
+}
+
+// SetLastProgressTime persists now as the last time forward progress was observed on the
+// rollout, so a later RolloutStatus call (possibly after a controller restart) can tell a
+// stalled rollout from one that simply hasn't reported in yet.
+func SetLastProgressTime(deployment *extensions.Deployment, now time.Time) {
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	deployment.Annotations[ProgressLastUpdateAnnotation] = now.Format(time.RFC3339)
+}
+
+// RolloutStatus computes the current DeploymentStatus of deployment by inspecting the RCs found
+// by GetNewRC/GetOldRCs and the ready-pod count from GetAvailablePodsForRCs.
+func RolloutStatus(deployment extensions.Deployment, c clientset.Interface) (DeploymentStatus, error) {
+	return RolloutStatusFromLists(deployment,
+		func(namespace string, options api.ListOptions) (*api.PodList, error) {
+			return c.Core().Pods(namespace).List(options)
+		},
+		func(namespace string, options api.ListOptions) ([]api.ReplicationController, error) {
+			rcList, err := c.Core().ReplicationControllers(namespace).List(options)
+			return rcList.Items, err
+		})
+}
+
+// RolloutStatusFromLists is the injectable-list variant of RolloutStatus, mirroring
+// GetOldRCsFromLists/GetNewRCFromList, so the rollout status logic can be driven in tests without
+// a live clientset.
+func RolloutStatusFromLists(deployment extensions.Deployment, getPodList func(string, api.ListOptions) (*api.PodList, error), getRcList func(string, api.ListOptions) ([]api.ReplicationController, error)) (DeploymentStatus, error) {
+	if steps, err := GetCanarySteps(deployment); err != nil {
+		return DeploymentStatus{}, err
+	} else if len(steps) > 0 {
+		index, transition, err := CurrentCanaryStep(deployment)
+		if err != nil {
+			return DeploymentStatus{}, err
+		}
+		if index >= 0 && index < len(steps) && IsCanaryStepPaused(deployment, steps[index], transition, time.Now()) {
+			return DeploymentStatus{
+				Status: RolloutPaused,
+				Reason: fmt.Sprintf("rollout is paused at canary step %d", index),
+			}, nil
+		}
+	}
+
+	newRC, err := GetNewRCFromList(deployment, nil, getRcList)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	if newRC == nil {
+		return DeploymentStatus{Status: RolloutProgressing, Reason: "waiting for the new replication controller to be created"}, nil
+	}
+	oldRCs, _, err := GetOldRCsFromLists(deployment, nil, getPodList, getRcList)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+
+	gates, err := ReadinessGates(deployment)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	desired := deployment.Spec.Replicas
+	updated := newRC.Spec.Replicas
+	available, err := GetAvailablePodsForRCsFromList([]*api.ReplicationController{newRC}, deployment.Spec.MinReadySeconds, gates, getPodList)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	oldReplicas := GetReplicaCountForRCs(oldRCs)
+
+	if updated == desired && available >= desired && oldReplicas == 0 {
+		return DeploymentStatus{Status: RolloutComplete, Reason: "all replicas have been updated and are available"}, nil
+	}
+
+	deadline, err := progressDeadline(deployment)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	lastProgress, err := lastProgressTime(deployment)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+	if !lastProgress.IsZero() && time.Since(lastProgress) > time.Duration(deadline)*time.Second {
+		return DeploymentStatus{
+			Status: RolloutStalled,
+			Reason: fmt.Sprintf("no progress observed in the last %ds (deadline %ds)", int(time.Since(lastProgress).Seconds()), deadline),
+		}, nil
+	}
+
+	return DeploymentStatus{
+		Status: RolloutProgressing,
+		Reason: fmt.Sprintf("%d of %d new replicas are updated and %d are available", updated, desired, available),
+	}, nil
+}
+
+// WaitForRolloutComplete polls RolloutStatus until it reports RolloutComplete, returning an error
+// if the rollout is found RolloutStalled or timeout elapses first. It re-fetches the deployment
+// from the API on every tick: RolloutStatus reads live signals off the deployment object itself
+// (ProgressLastUpdateAnnotation the controller bumps as it makes progress, CanaryPausedAnnotation
+// a human flips to resume a step, even Spec.Replicas), so polling a single stale snapshot would
+// freeze those signals at the moment the wait started instead of tracking the server.
+func WaitForRolloutComplete(deployment extensions.Deployment, c clientset.Interface, timeout time.Duration) error {
+	namespace, name := deployment.Namespace, deployment.Name
+	return wait.Poll(time.Second, timeout, func() (bool, error) {
+		current, err := c.Extensions().Deployments(namespace).Get(name)
+		if err != nil {
+			return false, err
+		}
+		status, err := RolloutStatus(*current, c)
+		if err != nil {
+			return false, err
+		}
+		switch status.Status {
+		case RolloutComplete:
+			return true, nil
+		case RolloutStalled:
+			return false, fmt.Errorf("deployment %q rollout stalled: %s", name, status.Reason)
+		default:
+			return false, nil
+		}
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestProgressDeadline(t *testing.T) {
+	deployment := extensions.Deployment{}
+	deadline, err := progressDeadline(deployment)
+	if err != nil {
+		t.Fatalf("progressDeadline() error = %v", err)
+	}
+	if deadline != DefaultProgressDeadlineSeconds {
+		t.Errorf("progressDeadline() = %d, want default %d", deadline, DefaultProgressDeadlineSeconds)
+	}
+
+	deployment.Annotations = map[string]string{ProgressDeadlineSecondsAnnotation: "60"}
+	deadline, err = progressDeadline(deployment)
+	if err != nil {
+		t.Fatalf("progressDeadline() error = %v", err)
+	}
+	if deadline != 60 {
+		t.Errorf("progressDeadline() = %d, want overridden 60", deadline)
+	}
+}
+
+func TestLastProgressTimeRoundTrip(t *testing.T) {
+	deployment := &extensions.Deployment{}
+	now := time.Now().Truncate(time.Second)
+	SetLastProgressTime(deployment, now)
+
+	got, err := lastProgressTime(*deployment)
+	if err != nil {
+		t.Fatalf("lastProgressTime() error = %v", err)
+	}
+	if !got.Equal(now) {
+		t.Errorf("lastProgressTime() = %v, want %v", got, now)
+	}
+}
+
+func readyPod(name string, selector map[string]string) api.Pod {
+	return api.Pod{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default", Labels: selector},
+		Status: api.PodStatus{
+			Conditions: []api.PodCondition{
+				{Type: api.PodReady, Status: api.ConditionTrue, LastTransitionTime: unversioned.Time{Time: time.Now().Add(-time.Hour)}},
+			},
+		},
+	}
+}
+
+// rolloutFixture builds a deployment with a single new RC (already matching
+// GetNewRCTemplate(deployment)) and the getPodList/getRcList funcs RolloutStatusFromLists needs,
+// so each status test only has to vary replica/pod counts and annotations.
+func rolloutFixture(t *testing.T, desired int, rcReplicas int, readyPods int) (extensions.Deployment, func(string, api.ListOptions) (*api.PodList, error), func(string, api.ListOptions) ([]api.ReplicationController, error)) {
+	t.Helper()
+	selector := map[string]string{"app": "foo"}
+	deployment := extensions.Deployment{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+			// Treat the index as already migrated so both getRcList paths below behave identically,
+			// independent of the RCIndexMigratedAnnotation fallback tested in index_test.go.
+			Annotations: map[string]string{RCIndexMigratedAnnotation: "true"},
+		},
+		Spec: extensions.DeploymentSpec{
+			Replicas: desired,
+			Selector: selector,
+			Template: api.PodTemplateSpec{ObjectMeta: api.ObjectMeta{Labels: selector}},
+		},
+	}
+	newTemplate := GetNewRCTemplate(deployment)
+	newRC := api.ReplicationController{
+		ObjectMeta: api.ObjectMeta{Name: "new", Namespace: "default", Labels: map[string]string{DeploymentNameLabel: "foo"}},
+		Spec:       api.ReplicationControllerSpec{Replicas: rcReplicas, Selector: selector, Template: &newTemplate},
+	}
+
+	pods := make([]api.Pod, 0, readyPods)
+	for i := 0; i < readyPods; i++ {
+		pods = append(pods, readyPod(string(rune('a'+i)), selector))
+	}
+
+	getPodList := func(namespace string, options api.ListOptions) (*api.PodList, error) {
+		return &api.PodList{Items: pods}, nil
+	}
+	getRcList := func(namespace string, options api.ListOptions) ([]api.ReplicationController, error) {
+		return []api.ReplicationController{newRC}, nil
+	}
+	return deployment, getPodList, getRcList
+}
+
+func TestRolloutStatusFromListsComplete(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 2)
+
+	status, err := RolloutStatusFromLists(deployment, getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("RolloutStatusFromLists() error = %v", err)
+	}
+	if status.Status != RolloutComplete {
+		t.Errorf("RolloutStatusFromLists() = %+v, want Status = %s", status, RolloutComplete)
+	}
+}
+
+func TestRolloutStatusFromListsProgressing(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 1)
+
+	status, err := RolloutStatusFromLists(deployment, getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("RolloutStatusFromLists() error = %v", err)
+	}
+	if status.Status != RolloutProgressing {
+		t.Errorf("RolloutStatusFromLists() = %+v, want Status = %s", status, RolloutProgressing)
+	}
+}
+
+func TestRolloutStatusFromListsStalled(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 2, 1)
+	deployment.Annotations[ProgressDeadlineSecondsAnnotation] = "60"
+	deployment.Annotations[ProgressLastUpdateAnnotation] = time.Now().Add(-2 * time.Minute).Format(time.RFC3339)
+
+	status, err := RolloutStatusFromLists(deployment, getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("RolloutStatusFromLists() error = %v", err)
+	}
+	if status.Status != RolloutStalled {
+		t.Errorf("RolloutStatusFromLists() = %+v, want Status = %s", status, RolloutStalled)
+	}
+}
+
+func TestRolloutStatusFromListsPaused(t *testing.T) {
+	deployment, getPodList, getRcList := rolloutFixture(t, 2, 1, 1)
+	duration := time.Hour
+	if err := SetCanarySteps(&deployment, []CanaryStep{{Pause: &CanaryPause{Duration: &duration}}}); err != nil {
+		t.Fatalf("SetCanarySteps() error = %v", err)
+	}
+	SetCurrentCanaryStep(&deployment, 0, time.Now())
+
+	status, err := RolloutStatusFromLists(deployment, getPodList, getRcList)
+	if err != nil {
+		t.Fatalf("RolloutStatusFromLists() error = %v", err)
+	}
+	if status.Status != RolloutPaused {
+		t.Errorf("RolloutStatusFromLists() = %+v, want Status = %s", status, RolloutPaused)
+	}
+}